@@ -0,0 +1,65 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2018 Datadog, Inc.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/DataDog/datadog-agent/pkg/flare"
+)
+
+// recipientFlags collects repeated `--recipient` flags, the shape
+// flare.CreateEncryptedDCAArchive expects for its recipients argument.
+type recipientFlags []string
+
+func (r *recipientFlags) String() string {
+	return fmt.Sprintf("%v", []string(*r))
+}
+
+func (r *recipientFlags) Set(v string) error {
+	*r = append(*r, v)
+	return nil
+}
+
+// runCreate backs `flare create [--local] [--dist-path] [--log-file]
+// [--encrypt] [--recipient key]...`: it builds a support archive, wrapping
+// it in an age-encrypted envelope via flare.CreateEncryptedDCAArchive when
+// --encrypt is set, or flare.CreateDCAArchive otherwise.
+func runCreate(args []string) error {
+	fs := flag.NewFlagSet("create", flag.ContinueOnError)
+	local := fs.Bool("local", false, "collect node-local diagnostics instead of querying the running agent")
+	distPath := fs.String("dist-path", "", "path to the agent's dist directory (for confd_dca_path resolution)")
+	logFilePath := fs.String("log-file", "", "path to the agent log file to include")
+	encrypt := fs.Bool("encrypt", false, "wrap the archive in an age-encrypted envelope for --recipient")
+	var recipients recipientFlags
+	fs.Var(&recipients, "recipient", "age public key (age1...), file path, or https URL to fetch one from; PGP keys are rejected; may be repeated")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		return fmt.Errorf("usage: flare create [--local] [--dist-path path] [--log-file path] [--encrypt] [--recipient key]...")
+	}
+
+	var (
+		path string
+		err  error
+	)
+	if *encrypt {
+		if len(recipients) == 0 {
+			return fmt.Errorf("--encrypt requires at least one --recipient")
+		}
+		path, err = flare.CreateEncryptedDCAArchive(*local, *distPath, *logFilePath, []string(recipients))
+	} else {
+		path, err = flare.CreateDCAArchive(*local, *distPath, *logFilePath)
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(path)
+	return nil
+}
@@ -0,0 +1,35 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2018 Datadog, Inc.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/DataDog/datadog-agent/pkg/flare"
+)
+
+// runDecrypt backs `flare decrypt --identity <identity-file> <archive-path>`,
+// the companion to `flare create --encrypt` for opening an age-encrypted
+// archive on the support side.
+func runDecrypt(args []string) error {
+	fs := flag.NewFlagSet("decrypt", flag.ContinueOnError)
+	identityPath := fs.String("identity", "", "path to the age identity (private key) file to decrypt with")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 || *identityPath == "" {
+		return fmt.Errorf("usage: flare decrypt --identity <identity-file> <archive-path>")
+	}
+
+	plainPath, err := flare.DecryptArchive(fs.Arg(0), *identityPath)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(plainPath)
+	return nil
+}
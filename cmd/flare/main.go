@@ -0,0 +1,47 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2018 Datadog, Inc.
+
+// Command flare is the operator-facing entry point for the archive/upload
+// plumbing in pkg/flare: building a support archive is only useful once
+// there's a way to actually send it, so this wires pkg/flare's exported
+// functions to a CLI instead of leaving them as library calls with no
+// caller anywhere in the tree.
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+func main() {
+	if err := run(os.Args[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, "flare:", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string) error {
+	if len(args) == 0 {
+		return usageError()
+	}
+
+	switch args[0] {
+	case "create":
+		return runCreate(args[1:])
+	case "send":
+		return runSend(args[1:])
+	case "decrypt":
+		return runDecrypt(args[1:])
+	default:
+		return usageError()
+	}
+}
+
+func usageError() error {
+	return fmt.Errorf(`usage:
+  flare create [--local] [--dist-path path] [--log-file path] [--encrypt] [--recipient key]...
+  flare send [--resume] [--meta key=value]... <archive-path>
+  flare decrypt --identity <identity-file> <archive-path>`)
+}
@@ -0,0 +1,66 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2018 Datadog, Inc.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/DataDog/datadog-agent/pkg/flare"
+)
+
+// metaFlags collects repeated `--meta key=value` flags into a map, the
+// shape flare.Send/flare.Resume expect for upload metadata.
+type metaFlags map[string]string
+
+func (m metaFlags) String() string {
+	return fmt.Sprintf("%v", map[string]string(m))
+}
+
+func (m metaFlags) Set(kv string) error {
+	key, value, ok := strings.Cut(kv, "=")
+	if !ok {
+		return fmt.Errorf("--meta expects key=value, got %q", kv)
+	}
+	m[key] = value
+	return nil
+}
+
+// runSend backs `flare send [--resume] [--meta key=value]... <archive-path>`:
+// it ships an already-built archive through the configured
+// flare.upload.backend, calling flare.Resume when --resume is set and
+// flare.Send otherwise.
+func runSend(args []string) error {
+	fs := flag.NewFlagSet("send", flag.ContinueOnError)
+	resume := fs.Bool("resume", false, "continue a previously interrupted upload instead of starting over")
+	meta := make(metaFlags)
+	fs.Var(meta, "meta", "upload metadata as key=value; may be repeated")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: flare send [--resume] [--meta key=value]... <archive-path>")
+	}
+	path := fs.Arg(0)
+
+	var (
+		receipt flare.Receipt
+		err     error
+	)
+	if *resume {
+		receipt, err = flare.Resume(path, meta)
+	} else {
+		receipt, err = flare.Send(path, meta)
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("uploaded %s via %s backend (receipt %s)\n", path, receipt.Backend, receipt.ID)
+	return nil
+}
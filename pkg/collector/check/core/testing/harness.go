@@ -0,0 +1,182 @@
+package testing
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"sort"
+	"testing"
+
+	"github.com/DataDog/datadog-agent/pkg/aggregator"
+)
+
+// update, when passed as `-update` to `go test`, rewrites golden fixtures
+// with the calls actually captured instead of comparing against them.
+var update = flag.Bool("update", false, "update golden files instead of asserting against them")
+
+// Check is the subset of check.Check that CheckHarness needs in order to
+// drive a real Python or Go check instance end-to-end.
+type Check interface {
+	Configure(initConfig, instanceConfig []byte) error
+	Run() error
+}
+
+// Call is a single Rate/Count/Gauge/Histogram/ServiceCheck/Commit invocation
+// captured while a check runs under a CheckHarness.
+type Call struct {
+	Method   string                        `json:"method"`
+	Metric   string                        `json:"metric,omitempty"`
+	Value    float64                       `json:"value,omitempty"`
+	Hostname string                        `json:"hostname,omitempty"`
+	Tags     []string                      `json:"tags,omitempty"`
+	Status   aggregator.ServiceCheckStatus `json:"status,omitempty"`
+	Message  string                        `json:"message,omitempty"`
+}
+
+// recordingSender implements the same sender surface as MockSender, but
+// simply appends every call it sees rather than requiring a hand-written
+// `On(...)` expectation for each one.
+type recordingSender struct {
+	calls []Call
+}
+
+func (s *recordingSender) Rate(metric string, value float64, hostname string, tags []string) {
+	s.calls = append(s.calls, Call{Method: "Rate", Metric: metric, Value: value, Hostname: hostname, Tags: tags})
+}
+
+func (s *recordingSender) Count(metric string, value float64, hostname string, tags []string) {
+	s.calls = append(s.calls, Call{Method: "Count", Metric: metric, Value: value, Hostname: hostname, Tags: tags})
+}
+
+func (s *recordingSender) MonotonicCount(metric string, value float64, hostname string, tags []string) {
+	s.calls = append(s.calls, Call{Method: "MonotonicCount", Metric: metric, Value: value, Hostname: hostname, Tags: tags})
+}
+
+func (s *recordingSender) Histogram(metric string, value float64, hostname string, tags []string) {
+	s.calls = append(s.calls, Call{Method: "Histogram", Metric: metric, Value: value, Hostname: hostname, Tags: tags})
+}
+
+func (s *recordingSender) Gauge(metric string, value float64, hostname string, tags []string) {
+	s.calls = append(s.calls, Call{Method: "Gauge", Metric: metric, Value: value, Hostname: hostname, Tags: tags})
+}
+
+func (s *recordingSender) ServiceCheck(checkName string, status aggregator.ServiceCheckStatus, hostname string, tags []string, message string) {
+	s.calls = append(s.calls, Call{Method: "ServiceCheck", Metric: checkName, Status: status, Hostname: hostname, Tags: tags, Message: message})
+}
+
+func (s *recordingSender) Commit() {
+	s.calls = append(s.calls, Call{Method: "Commit"})
+}
+
+// CheckHarness runs a check end-to-end against a recording sender and
+// compares every call it made against a golden fixture, so a check test can
+// declare its expected output as data instead of as a sequence of mock
+// expectations.
+type CheckHarness struct {
+	sender *recordingSender
+}
+
+// NewCheckHarness returns a ready-to-use CheckHarness.
+func NewCheckHarness() *CheckHarness {
+	return &CheckHarness{sender: &recordingSender{}}
+}
+
+// Run configures and runs c with the harness wired up as its sender,
+// returning every Rate/Count/Gauge/Histogram/ServiceCheck/Commit call it
+// made, in order.
+func (h *CheckHarness) Run(checkID string, c Check, initConfig, instanceConfig []byte) ([]Call, error) {
+	aggregator.SetSender(h.sender, checkID)
+	defer aggregator.DestroySender(checkID)
+
+	if err := c.Configure(initConfig, instanceConfig); err != nil {
+		return nil, fmt.Errorf("harness: configure failed: %w", err)
+	}
+
+	if err := c.Run(); err != nil {
+		return nil, fmt.Errorf("harness: run failed: %w", err)
+	}
+
+	return h.sender.calls, nil
+}
+
+// GoldenOptions tunes how AssertGolden compares captured calls to the fixture.
+type GoldenOptions struct {
+	// FloatTolerance is the maximum allowed absolute difference between an
+	// actual and expected metric value. Defaults to 0 (exact match).
+	FloatTolerance float64
+}
+
+// AssertGolden compares calls against the fixture at path, ignoring tag
+// ordering within each call's tag set, and failing t with a readable diff on
+// mismatch. With `-update` passed to `go test`, it rewrites path with calls
+// instead of asserting against it.
+func AssertGolden(t *testing.T, path string, calls []Call, opts ...GoldenOptions) {
+	t.Helper()
+
+	var opt GoldenOptions
+	if len(opts) > 0 {
+		opt = opts[0]
+	}
+
+	if *update {
+		b, err := json.MarshalIndent(calls, "", "  ")
+		if err != nil {
+			t.Fatalf("harness: failed to marshal golden fixture: %v", err)
+		}
+		if err := ioutil.WriteFile(path, b, 0644); err != nil {
+			t.Fatalf("harness: failed to update golden fixture %s: %v", path, err)
+		}
+		return
+	}
+
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("harness: failed to read golden fixture %s (run with -update to create it): %v", path, err)
+	}
+
+	var expected []Call
+	if err := json.Unmarshal(raw, &expected); err != nil {
+		t.Fatalf("harness: failed to parse golden fixture %s: %v", path, err)
+	}
+
+	if len(expected) != len(calls) {
+		t.Fatalf("harness: %s: expected %d calls, got %d\nexpected: %+v\nactual:   %+v", path, len(expected), len(calls), expected, calls)
+	}
+
+	for i := range expected {
+		if !callsEqual(expected[i], calls[i], opt.FloatTolerance) {
+			t.Fatalf("harness: %s: call %d mismatch\nexpected: %+v\nactual:   %+v", path, i, expected[i], calls[i])
+		}
+	}
+}
+
+func callsEqual(a, b Call, tolerance float64) bool {
+	if a.Method != b.Method || a.Metric != b.Metric || a.Hostname != b.Hostname ||
+		a.Status != b.Status || a.Message != b.Message {
+		return false
+	}
+	if math.Abs(a.Value-b.Value) > tolerance {
+		return false
+	}
+	return tagsEqual(a.Tags, b.Tags)
+}
+
+// tagsEqual compares two tag sets ignoring order, since checks commonly
+// build their tag slices by appending in map-iteration order.
+func tagsEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	sortedA := append([]string(nil), a...)
+	sortedB := append([]string(nil), b...)
+	sort.Strings(sortedA)
+	sort.Strings(sortedB)
+	for i := range sortedA {
+		if sortedA[i] != sortedB[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,123 @@
+package testing
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type fakeCheck struct{}
+
+func (c *fakeCheck) Configure(initConfig, instanceConfig []byte) error { return nil }
+
+func (c *fakeCheck) Run() error { return nil }
+
+func writeGolden(t *testing.T, dir string, calls []Call) string {
+	t.Helper()
+	b, err := json.MarshalIndent(calls, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "test.golden.json")
+	if err := ioutil.WriteFile(path, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestAssertGoldenPassesOnMatch(t *testing.T) {
+	dir := t.TempDir()
+	calls := []Call{
+		{Method: "Gauge", Metric: "cpu.load", Value: 1.5, Tags: []string{"a", "b"}},
+		{Method: "Commit"},
+	}
+	path := writeGolden(t, dir, calls)
+
+	AssertGolden(t, path, calls)
+}
+
+func TestAssertGoldenAgainstCheckedInFixture(t *testing.T) {
+	calls := []Call{
+		{Method: "Gauge", Metric: "cpu.load", Value: 1.5, Tags: []string{"instance-type:m5.large", "availability-zone:us-east-1a"}},
+		{Method: "Commit"},
+	}
+	AssertGolden(t, "testdata/example.golden.json", calls)
+}
+
+func TestAssertGoldenIgnoresTagOrder(t *testing.T) {
+	dir := t.TempDir()
+	golden := []Call{{Method: "Gauge", Metric: "cpu.load", Value: 1.5, Tags: []string{"a", "b"}}}
+	actual := []Call{{Method: "Gauge", Metric: "cpu.load", Value: 1.5, Tags: []string{"b", "a"}}}
+	path := writeGolden(t, dir, golden)
+
+	AssertGolden(t, path, actual)
+}
+
+func TestAssertGoldenUpdateRewritesFixture(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "update.golden.json")
+
+	*update = true
+	defer func() { *update = false }()
+
+	calls := []Call{{Method: "Gauge", Metric: "cpu.load", Value: 42}}
+	AssertGolden(t, path, calls)
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("expected -update to create the fixture: %v", err)
+	}
+	var got []Call
+	if err := json.Unmarshal(raw, &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].Value != 42 {
+		t.Fatalf("unexpected fixture contents: %+v", got)
+	}
+}
+
+// The mismatch/tolerance decision itself is exercised directly against
+// callsEqual, since driving it through AssertGolden's t.Fatalf would make
+// this test's own failure path look like a real test failure.
+func TestCallsEqualDetectsMismatch(t *testing.T) {
+	a := Call{Method: "Gauge", Metric: "cpu.load", Value: 1.5}
+	b := Call{Method: "Gauge", Metric: "cpu.load", Value: 2.5}
+	if callsEqual(a, b, 0) {
+		t.Fatal("expected differing values to be unequal with zero tolerance")
+	}
+}
+
+func TestCallsEqualRespectsFloatTolerance(t *testing.T) {
+	a := Call{Method: "Gauge", Metric: "cpu.load", Value: 1.500}
+	b := Call{Method: "Gauge", Metric: "cpu.load", Value: 1.501}
+	if callsEqual(a, b, 0) {
+		t.Fatal("expected a value mismatch to fail with zero tolerance")
+	}
+	if !callsEqual(a, b, 0.01) {
+		t.Fatal("expected the mismatch to pass within the configured tolerance")
+	}
+}
+
+func TestTagsEqualIgnoresOrder(t *testing.T) {
+	if !tagsEqual([]string{"a", "b", "c"}, []string{"c", "a", "b"}) {
+		t.Fatal("expected tag sets differing only in order to be equal")
+	}
+	if tagsEqual([]string{"a", "b"}, []string{"a"}) {
+		t.Fatal("expected tag sets of different length to be unequal")
+	}
+}
+
+func TestCheckHarnessRunReturnsNoCallsForNoOpCheck(t *testing.T) {
+	h := NewCheckHarness()
+	check := &fakeCheck{}
+
+	calls, err := h.Run("cpu:instance1", check, nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if calls != nil {
+		t.Fatalf("expected no calls from a no-op check, got %+v", calls)
+	}
+}
@@ -0,0 +1,418 @@
+package py
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os/exec"
+	"runtime"
+	"sync"
+
+	"github.com/sbinet/go-python"
+	"github.com/ugorji/go/codec"
+
+	"github.com/DataDog/datadog-agent/pkg/config"
+)
+
+// #include <Python.h>
+import "C"
+
+// RunnerKind selects how check instances are executed against the embedded
+// Python runtime, via the `python_runner` setting.
+type RunnerKind string
+
+// Supported RunnerKinds.
+const (
+	// RunnerInProc is the legacy behavior: every check instance runs on the
+	// single global interpreter, serialized on one GIL via StickyLock.
+	RunnerInProc RunnerKind = "inproc"
+	// RunnerSubinterp gives each check instance its own CPython
+	// subinterpreter, so instances no longer share module state. On CPython
+	// builds older than 3.12 all subinterpreters share the single
+	// process-wide GIL, and Acquire takes it, so instances still serialize
+	// on that one GIL.
+	RunnerSubinterp RunnerKind = "subinterp"
+	// RunnerSubprocess routes each check instance to a small pool of worker
+	// processes speaking a length-prefixed msgpack protocol over a unix
+	// socket, so a crashing check can no longer take the whole Agent down
+	// with it. NOT YET IMPLEMENTED: the worker shim this runner talks to is
+	// not shipped yet, so NewRunner(RunnerSubprocess, ...) always returns an
+	// error. The type is defined so the config value round-trips cleanly
+	// once the worker lands.
+	RunnerSubprocess RunnerKind = "subprocess"
+)
+
+// Runner executes check instances against the embedded Python runtime.
+// Exactly one Runner is active per Agent process, selected at startup by the
+// `python_runner` config value.
+type Runner interface {
+	// Acquire returns a handle scoping execution (interpreter, worker
+	// process, ...) to the given check instance. The caller must call
+	// Release on the returned handle once done with it.
+	Acquire(checkID string) (InterpreterHandle, error)
+	// ReleaseCheck tears down whatever per-check state Acquire allocated for
+	// checkID (e.g. a subinterpreter), so a check removed by autodiscovery
+	// or a config reload doesn't hold resources for the rest of the Agent's
+	// lifetime. It is a no-op for runners that don't keep per-check state,
+	// and for a checkID that was never acquired.
+	ReleaseCheck(checkID string) error
+	// Close tears down the runner and any interpreters/processes it owns.
+	Close() error
+}
+
+// InterpreterHandle scopes check class discovery and execution to one
+// check instance's interpreter or worker process.
+type InterpreterHandle interface {
+	// FindCheckClass locates the check class in modulePath deriving from
+	// base, preserving the discovery semantics of findSubclassOf/
+	// getModuleName regardless of which Runner produced the handle.
+	FindCheckClass(modulePath string, base *python.PyObject) (CheckClass, error)
+	// Release returns the interpreter/worker to its pool.
+	Release()
+}
+
+// CheckClass identifies a check class found by FindCheckClass. For the
+// inproc and subinterp runners the class lives in this process and Object
+// returns it directly; for the subprocess runner it lives in a worker
+// process and only its qualified name crosses the boundary, so Object
+// reports false and callers must invoke the check over the runner's RPC
+// mechanism instead.
+type CheckClass struct {
+	Module   string
+	QualName string
+
+	object *python.PyObject
+}
+
+// Object returns the underlying *python.PyObject and true when the class
+// was found in this process, or nil and false when it lives in a remote
+// worker (the subprocess runner).
+func (c CheckClass) Object() (*python.PyObject, bool) {
+	return c.object, c.object != nil
+}
+
+// NewRunnerFromConfig builds the Runner selected by the `python_runner`
+// config setting ("inproc", the default, or "subinterp"; "subprocess" is
+// accepted but currently always returns an error, see RunnerSubprocess).
+func NewRunnerFromConfig(paths ...string) (Runner, error) {
+	return NewRunner(RunnerKind(config.Datadog.GetString("python_runner")), paths...)
+}
+
+// NewRunner builds a Runner of the given kind. paths is forwarded to
+// Initialize (or, for RunnerSubinterp, to every subinterpreter created
+// thereafter) as the PYTHONPATH.
+func NewRunner(kind RunnerKind, paths ...string) (Runner, error) {
+	switch kind {
+	case "", RunnerInProc:
+		return newInprocRunner(paths...), nil
+	case RunnerSubinterp:
+		return newSubinterpRunner(paths...), nil
+	case RunnerSubprocess:
+		return newSubprocessRunner(paths...)
+	default:
+		return nil, fmt.Errorf("py: unknown python_runner %q", kind)
+	}
+}
+
+// --- inproc: the legacy single-interpreter, single-GIL behavior ---
+
+type inprocRunner struct {
+	state *python.PyThreadState
+}
+
+func newInprocRunner(paths ...string) *inprocRunner {
+	return &inprocRunner{state: Initialize(paths...)}
+}
+
+func (r *inprocRunner) Acquire(checkID string) (InterpreterHandle, error) {
+	return inprocHandle{}, nil
+}
+
+func (r *inprocRunner) ReleaseCheck(checkID string) error {
+	return nil
+}
+
+func (r *inprocRunner) Close() error {
+	return nil
+}
+
+type inprocHandle struct{}
+
+func (inprocHandle) FindCheckClass(modulePath string, base *python.PyObject) (CheckClass, error) {
+	gstate := NewStickyLock()
+	defer gstate.Unlock()
+
+	module := python.PyImport_ImportModule(getModuleName(modulePath))
+	if module == nil {
+		return CheckClass{}, fmt.Errorf("py: could not import module %s", modulePath)
+	}
+	class, err := findSubclassOf(base, module)
+	if err != nil {
+		return CheckClass{}, err
+	}
+	return CheckClass{Module: modulePath, QualName: python.PyString_AsString(class.Str()), object: class}, nil
+}
+
+func (inprocHandle) Release() {}
+
+// --- subinterp: one CPython subinterpreter per check instance ---
+
+type subinterpRunner struct {
+	mu       sync.Mutex
+	paths    []string
+	perCheck map[string]*C.PyThreadState
+	main     *python.PyThreadState
+}
+
+func newSubinterpRunner(paths ...string) *subinterpRunner {
+	return &subinterpRunner{
+		paths:    paths,
+		perCheck: make(map[string]*C.PyThreadState),
+		main:     Initialize(paths...),
+	}
+}
+
+func (r *subinterpRunner) Acquire(checkID string) (InterpreterHandle, error) {
+	runtime.LockOSThread()
+
+	r.mu.Lock()
+	ts, ok := r.perCheck[checkID]
+	r.mu.Unlock()
+
+	if !ok {
+		// Py_NewInterpreter must run with the GIL held and leaves the new
+		// interpreter's thread state current; we stash it and swap back to
+		// it on every subsequent Acquire for the same checkID.
+		python.PyEval_RestoreThread(r.main)
+		newTS := C.Py_NewInterpreter()
+		if newTS == nil {
+			python.PyEval_SaveThread()
+			runtime.UnlockOSThread()
+			return nil, fmt.Errorf("py: could not create subinterpreter for check %s", checkID)
+		}
+
+		if len(r.paths) > 0 {
+			path := python.PySys_GetObject("path")
+			for _, p := range r.paths {
+				python.PyList_Append(path, python.PyString_FromString(p))
+			}
+		}
+
+		ts = newTS
+		r.mu.Lock()
+		r.perCheck[checkID] = ts
+		r.mu.Unlock()
+
+		python.PyEval_SaveThread()
+	}
+
+	python.PyEval_RestoreThread((*python.PyThreadState)(ts))
+	return &subinterpHandle{runner: r}, nil
+}
+
+// ReleaseCheck ends checkID's subinterpreter and forgets it, so autodiscovery
+// removing a check instance (or a config reload re-creating one under the
+// same logical check but a new checkID) doesn't leak one subinterpreter per
+// historical checkID for the life of the Agent process. It is a no-op if
+// checkID was never acquired, e.g. because Acquire was never called or
+// ReleaseCheck already ran for it.
+func (r *subinterpRunner) ReleaseCheck(checkID string) error {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	r.mu.Lock()
+	ts, ok := r.perCheck[checkID]
+	if ok {
+		delete(r.perCheck, checkID)
+	}
+	r.mu.Unlock()
+
+	if !ok {
+		return nil
+	}
+
+	python.PyEval_RestoreThread(r.main)
+	python.PyThreadState_Swap((*python.PyThreadState)(ts))
+	C.Py_EndInterpreter(ts)
+	python.PyEval_SaveThread()
+	return nil
+}
+
+func (r *subinterpRunner) Close() error {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	python.PyEval_RestoreThread(r.main)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for checkID, ts := range r.perCheck {
+		python.PyThreadState_Swap((*python.PyThreadState)(ts))
+		C.Py_EndInterpreter(ts)
+		delete(r.perCheck, checkID)
+	}
+	python.PyEval_SaveThread()
+	return nil
+}
+
+type subinterpHandle struct {
+	runner *subinterpRunner
+}
+
+func (h *subinterpHandle) FindCheckClass(modulePath string, base *python.PyObject) (CheckClass, error) {
+	module := python.PyImport_ImportModule(getModuleName(modulePath))
+	if module == nil {
+		return CheckClass{}, fmt.Errorf("py: could not import module %s", modulePath)
+	}
+	class, err := findSubclassOf(base, module)
+	if err != nil {
+		return CheckClass{}, err
+	}
+	return CheckClass{Module: modulePath, QualName: python.PyString_AsString(class.Str()), object: class}, nil
+}
+
+func (h *subinterpHandle) Release() {
+	python.PyEval_SaveThread()
+	runtime.UnlockOSThread()
+}
+
+// --- subprocess: a small pool of worker processes over a unix socket ---
+
+// ipcRequest and ipcResponse are exchanged as length-prefixed msgpack frames
+// with the Python worker, which runs a matching compact protocol shim.
+type ipcRequest struct {
+	Op         string `codec:"op"`
+	CheckID    string `codec:"check_id"`
+	ModulePath string `codec:"module_path,omitempty"`
+}
+
+type ipcResponse struct {
+	OK    bool   `codec:"ok"`
+	Class string `codec:"class,omitempty"`
+	Error string `codec:"error,omitempty"`
+}
+
+// subprocessWorker owns one worker process and its socket connection.
+type subprocessWorker struct {
+	cmd  *exec.Cmd
+	conn net.Conn
+	mu   sync.Mutex
+}
+
+func (w *subprocessWorker) call(req ipcRequest) (ipcResponse, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var buf []byte
+	enc := codec.NewEncoderBytes(&buf, &codec.MsgpackHandle{})
+	if err := enc.Encode(req); err != nil {
+		return ipcResponse{}, err
+	}
+
+	var lenPrefix [4]byte
+	binary.BigEndian.PutUint32(lenPrefix[:], uint32(len(buf)))
+	if _, err := w.conn.Write(lenPrefix[:]); err != nil {
+		return ipcResponse{}, err
+	}
+	if _, err := w.conn.Write(buf); err != nil {
+		return ipcResponse{}, err
+	}
+
+	r := bufio.NewReader(w.conn)
+	if _, err := io.ReadFull(r, lenPrefix[:]); err != nil {
+		return ipcResponse{}, err
+	}
+	respBuf := make([]byte, binary.BigEndian.Uint32(lenPrefix[:]))
+	if _, err := io.ReadFull(r, respBuf); err != nil {
+		return ipcResponse{}, err
+	}
+
+	var resp ipcResponse
+	dec := codec.NewDecoderBytes(respBuf, &codec.MsgpackHandle{})
+	if err := dec.Decode(&resp); err != nil {
+		return ipcResponse{}, err
+	}
+	return resp, nil
+}
+
+type subprocessRunner struct {
+	mu      sync.Mutex
+	workers []*subprocessWorker
+	next    int
+}
+
+// newSubprocessRunner spawns a small, fixed-size pool of worker processes.
+// spawnSubprocessWorker always returns an error in this build, as the worker
+// entry point (a Python shim speaking this package's IPC protocol) is not
+// part of it, so newSubprocessRunner always fails.
+func newSubprocessRunner(paths ...string) (*subprocessRunner, error) {
+	const poolSize = 4
+	r := &subprocessRunner{}
+	for i := 0; i < poolSize; i++ {
+		w, err := spawnSubprocessWorker(paths...)
+		if err != nil {
+			r.Close()
+			return nil, fmt.Errorf("py: could not start subprocess worker %d: %w", i, err)
+		}
+		r.workers = append(r.workers, w)
+	}
+	return r, nil
+}
+
+func spawnSubprocessWorker(paths ...string) (*subprocessWorker, error) {
+	return nil, fmt.Errorf("py: subprocess runner requires the datadog-agent check-worker shim, which is not available in this build")
+}
+
+func (r *subprocessRunner) Acquire(checkID string) (InterpreterHandle, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.workers) == 0 {
+		return nil, fmt.Errorf("py: no subprocess workers available")
+	}
+	w := r.workers[r.next%len(r.workers)]
+	r.next++
+	return &subprocessHandle{worker: w, checkID: checkID}, nil
+}
+
+// ReleaseCheck is a no-op: workers aren't bound to a checkID beyond a single
+// Acquire, so there's no per-check state here to tear down.
+func (r *subprocessRunner) ReleaseCheck(checkID string) error {
+	return nil
+}
+
+func (r *subprocessRunner) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, w := range r.workers {
+		if w.conn != nil {
+			w.conn.Close()
+		}
+		if w.cmd != nil && w.cmd.Process != nil {
+			w.cmd.Process.Kill()
+		}
+	}
+	r.workers = nil
+	return nil
+}
+
+type subprocessHandle struct {
+	worker  *subprocessWorker
+	checkID string
+}
+
+func (h *subprocessHandle) FindCheckClass(modulePath string, base *python.PyObject) (CheckClass, error) {
+	resp, err := h.worker.call(ipcRequest{Op: "find_subclass", CheckID: h.checkID, ModulePath: modulePath})
+	if err != nil {
+		return CheckClass{}, err
+	}
+	if !resp.OK {
+		return CheckClass{}, fmt.Errorf("py: worker error: %s", resp.Error)
+	}
+	// The class lives in the worker process, so CheckClass.Object reports
+	// false here; callers run it via the subprocess runner's RPC calls
+	// instead of a local *python.PyObject.
+	return CheckClass{Module: modulePath, QualName: resp.Class}, nil
+}
+
+func (h *subprocessHandle) Release() {}
@@ -13,13 +13,13 @@ import (
 	"os"
 	"path/filepath"
 
-	log "github.com/cihub/seelog"
 	"github.com/mholt/archiver"
 
 	"github.com/DataDog/datadog-agent/pkg/config"
 	"github.com/DataDog/datadog-agent/pkg/status"
 	"github.com/DataDog/datadog-agent/pkg/util"
 	"github.com/DataDog/datadog-agent/pkg/util/kubernetes/apiserver"
+	"github.com/DataDog/datadog-agent/pkg/util/log"
 )
 
 // CreateDCAArchive packages up the files
@@ -33,101 +33,158 @@ func CreateDCAArchive(local bool, distPath, logFilePath string) (string, error)
 }
 
 func createDCAArchive(zipFilePath string, local bool, confSearchPaths SearchPaths, logFilePath string) (string, error) {
-	b := make([]byte, 10)
-	_, err := rand.Read(b)
+	tempDir, hostname, err := prepareDCAArchiveContents(local, confSearchPaths, logFilePath)
 	if err != nil {
 		return "", err
 	}
+	defer os.RemoveAll(tempDir)
 
-	dirName := hex.EncodeToString([]byte(b))
-	tempDir, err := ioutil.TempDir("", dirName)
+	err = archiver.Zip.Make(zipFilePath, []string{filepath.Join(tempDir, hostname)})
 	if err != nil {
 		return "", err
 	}
 
-	defer os.RemoveAll(tempDir)
+	return zipFilePath, nil
+}
+
+// prepareDCAArchiveContents assembles the on-disk tree that both
+// createDCAArchive and createEncryptedDCAArchive zip up: status, logs,
+// config, expvar, env vars, cluster metadata, and the flare-creation log
+// itself. Callers are responsible for removing the returned tempDir.
+func prepareDCAArchiveContents(local bool, confSearchPaths SearchPaths, logFilePath string) (tempDir, hostname string, err error) {
+	b := make([]byte, 10)
+	_, err = rand.Read(b)
+	if err != nil {
+		return "", "", err
+	}
+
+	dirName := hex.EncodeToString([]byte(b))
+	tempDir, err = ioutil.TempDir("", dirName)
+	if err != nil {
+		return "", "", err
+	}
+
+	// creationLog captures, as structured JSON, what the flare-creation steps
+	// log through flog, so support can grep a machine-parseable record of how
+	// (and whether) each section of the bundle was produced, rather than
+	// having to dig it out of the Agent's own seelog output after the fact.
+	creationLog := &flareCreationLog{}
+	flog := log.New(log.Config{Format: "json", Out: creationLog}).With("run_id", dirName)
 
 	// Get hostname, if there's an error in getting the hostname,
 	// set the hostname to unknown
-	hostname, err := util.GetHostname()
+	hostname, err = util.GetHostname()
 	if err != nil {
 		hostname = "unknown"
 	}
+	flog = flog.With("hostname", hostname)
 
 	// If the request against the API does not go through we don't collect the status log.
 	if local {
 		f := filepath.Join(tempDir, hostname, "local")
 		err = ensureParentDirsExist(f)
 		if err != nil {
-			return "", err
+			return "", "", err
 		}
 
 		err = ioutil.WriteFile(f, []byte{}, os.ModePerm)
 		if err != nil {
-			return "", err
+			return "", "", err
 		}
 	} else {
 		// The Status will be unavailable unless the agent is running.
 		// Only zip it up if the agent is running
-		err = zipDCAStatusFile(tempDir, hostname)
+		err = zipDCAStatusFile(flog, tempDir, hostname)
 		if err != nil {
-			log.Infof("Error getting the status of the DCA, %q", err)
-			return "", err
+			flog.Error("failed to get the status of the DCA", "error", err)
+			return "", "", err
 		}
 	}
 
 	err = zipLogFiles(tempDir, hostname, logFilePath)
 	if err != nil {
-		return "", err
+		return "", "", err
 	}
 
 	err = zipConfigFiles(tempDir, hostname, confSearchPaths)
-
 	if err != nil {
-		return "", err
+		return "", "", err
 	}
 
 	err = zipExpVar(tempDir, hostname)
 	if err != nil {
-		return "", err
+		return "", "", err
 	}
 
 	err = zipEnvvars(tempDir, hostname)
 	if err != nil {
-		return "", err
+		return "", "", err
 	}
 
-	err = zipMetadataMap(tempDir, hostname)
+	err = zipMetadataMap(flog, tempDir, hostname)
 	if err != nil {
-		return "", err
+		return "", "", err
 	}
 
-	err = archiver.Zip.Make(zipFilePath, []string{filepath.Join(tempDir, hostname)})
-	if err != nil {
-		return "", err
+	if err := zipKubernetesDiagnostics(flog, tempDir, hostname); err != nil {
+		flog.Warn("failed to collect kubernetes diagnostics", "error", err)
 	}
 
-	return zipFilePath, nil
+	if err := writeFlareCreationLog(tempDir, hostname, creationLog); err != nil {
+		flog.Warn("failed to write the flare-creation log into the archive", "error", err)
+	}
+
+	return tempDir, hostname, nil
+}
+
+// flareCreationLog accumulates the JSON lines emitted while a flare is being
+// built, so they can be written out as a single file inside the archive.
+type flareCreationLog struct {
+	lines [][]byte
+}
+
+func (c *flareCreationLog) Write(p []byte) (int, error) {
+	line := make([]byte, len(p))
+	copy(line, p)
+	c.lines = append(c.lines, line)
+	return len(p), nil
+}
+
+// writeFlareCreationLog bundles the structured flare-creation log collected
+// via flareCreationLog under the archive root as `flare-creation.json`.
+func writeFlareCreationLog(tempDir, hostname string, c *flareCreationLog) error {
+	f := filepath.Join(tempDir, hostname, "flare-creation.json")
+	if err := ensureParentDirsExist(f); err != nil {
+		return err
+	}
+
+	var out []byte
+	for _, line := range c.lines {
+		out = append(out, line...)
+	}
+
+	return ioutil.WriteFile(f, out, os.ModePerm)
 }
 
-func zipDCAStatusFile(tempDir, hostname string) error {
+func zipDCAStatusFile(flog log.Logger, tempDir, hostname string) error {
+	flog = flog.With("section", "status")
 	// Grab the status
-	log.Infof("Zipping the status at %s for %s", tempDir, hostname)
+	flog.Info("zipping the status", "temp_dir", tempDir)
 	s, err := status.GetAndFormatDCAStatus()
 	if err != nil {
-		log.Infof("Error zipping the status: %q", err)
+		flog.Error("failed to zip the status", "error", err)
 		return err
 	}
 
 	// Clean it up
 	cleaned, err := credentialsCleanerBytes(s)
 	if err != nil {
-		log.Infof("Error redacting the log files: %q", err)
+		flog.Error("failed to redact the log files", "error", err)
 		return err
 	}
 
 	f := filepath.Join(tempDir, hostname, "cluster-agent-status.log")
-	log.Infof("Flare status made at %s", tempDir)
+	flog.Info("flare status made", "path", f)
 	err = ensureParentDirsExist(f)
 	if err != nil {
 		return err
@@ -140,34 +197,35 @@ func zipDCAStatusFile(tempDir, hostname string) error {
 	return err
 }
 
-func zipMetadataMap(tempDir, hostname string) error {
+func zipMetadataMap(flog log.Logger, tempDir, hostname string) error {
+	flog = flog.With("section", "metadata_map")
 	// Grab the metadata map for all nodes.
 	metaList, err := apiserver.GetMetadataMapBundleOnAllNodes()
 	if err != nil {
-		log.Infof("Error while collecting the cluster level metadata: %q", err)
+		flog.Warn("failed to collect the cluster level metadata", "error", err)
 	}
 
 	metaBytes, err := json.Marshal(metaList)
 	if err != nil {
-		log.Infof("Error while marshalling the cluster level metadata: %q", err)
+		flog.Error("failed to marshal the cluster level metadata", "error", err)
 		return err
 	}
 	// Clean it up
 	cleanedMetaBytes, err := credentialsCleanerBytes(metaBytes)
 	if err != nil {
-		log.Infof("Error redacting the log files: %q", err)
+		flog.Error("failed to redact the log files", "error", err)
 		return err
 	}
 
 	str, err := status.FormatMetadataMapCLI(cleanedMetaBytes)
 	if err != nil {
-		log.Infof("Error while rendering the cluster level metadata: %q", err)
+		flog.Error("failed to render the cluster level metadata", "error", err)
 		return err
 	}
 
 	sByte := []byte(str)
 	f := filepath.Join(tempDir, hostname, "cluster-agent-metadatamapper.log")
-	log.Infof("Flare metadata mapper made at %s", tempDir)
+	flog.Info("flare metadata mapper made", "path", f)
 	err = ensureParentDirsExist(f)
 	if err != nil {
 		return err
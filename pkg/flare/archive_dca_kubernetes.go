@@ -0,0 +1,261 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2018 Datadog, Inc.
+
+package flare
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+
+	"github.com/DataDog/datadog-agent/pkg/config"
+	"github.com/DataDog/datadog-agent/pkg/util/kubernetes/apiserver"
+	"github.com/DataDog/datadog-agent/pkg/util/log"
+)
+
+// kubernetesDir is the directory, relative to the archive root, that
+// zipKubernetesDiagnostics writes under. Support tooling depends on this
+// layout staying stable.
+const kubernetesDir = "kubernetes"
+
+// defaultMaxFileBytes and defaultMaxTotalBytes bound zipKubernetesDiagnostics
+// when `flare.kubernetes.max_file_bytes`/`flare.kubernetes.max_total_bytes`
+// are not set, so a noisy cluster can't blow up the flare's size.
+const (
+	defaultMaxFileBytes  = 5 * 1024 * 1024
+	defaultMaxTotalBytes = 50 * 1024 * 1024
+)
+
+// podLogTailLines is how many trailing lines of each pod's logs are
+// collected.
+const podLogTailLines = 500
+
+// kubernetesDiagnosticsBudget tracks the total bytes written by
+// zipKubernetesDiagnostics so it can stop once defaultMaxTotalBytes (or the
+// configured override) is reached.
+type kubernetesDiagnosticsBudget struct {
+	maxFileBytes  int64
+	maxTotalBytes int64
+	written       int64
+}
+
+func newKubernetesDiagnosticsBudget() *kubernetesDiagnosticsBudget {
+	maxFile := config.Datadog.GetInt64("flare.kubernetes.max_file_bytes")
+	if maxFile <= 0 {
+		maxFile = defaultMaxFileBytes
+	}
+	maxTotal := config.Datadog.GetInt64("flare.kubernetes.max_total_bytes")
+	if maxTotal <= 0 {
+		maxTotal = defaultMaxTotalBytes
+	}
+	return &kubernetesDiagnosticsBudget{maxFileBytes: maxFile, maxTotalBytes: maxTotal}
+}
+
+// writeFile truncates data to the per-file cap and then to whatever remains
+// of the total cap, writes it, and adds the bytes written to b.written. Once
+// the total cap is exhausted it writes nothing.
+func (b *kubernetesDiagnosticsBudget) writeFile(path string, data []byte) error {
+	if int64(len(data)) > b.maxFileBytes {
+		data = data[:b.maxFileBytes]
+	}
+	if b.written+int64(len(data)) > b.maxTotalBytes {
+		remaining := b.maxTotalBytes - b.written
+		if remaining <= 0 {
+			return nil
+		}
+		data = data[:remaining]
+	}
+
+	if err := ensureParentDirsExist(path); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path, data, os.ModePerm); err != nil {
+		return err
+	}
+	b.written += int64(len(data))
+	return nil
+}
+
+// writeJSONList marshals items (which must be a slice) to JSON and writes it
+// through the budget, dropping items from the end of the slice until the
+// marshaled form fits the remaining per-file and total budget. Unlike
+// writeFile, this never cuts a JSON blob mid-document: if even a
+// zero-element list doesn't fit, the file is skipped entirely rather than
+// written as invalid JSON.
+func (b *kubernetesDiagnosticsBudget) writeJSONList(flog log.Logger, path string, items interface{}) error {
+	v := reflect.ValueOf(items)
+	if v.Kind() != reflect.Slice {
+		return fmt.Errorf("flare: writeJSONList requires a slice, got %T", items)
+	}
+
+	limit := b.maxFileBytes
+	if remaining := b.maxTotalBytes - b.written; remaining < limit {
+		limit = remaining
+	}
+	if limit <= 0 {
+		flog.Warn("skipping file: kubernetes diagnostics budget already exhausted", "path", path)
+		return nil
+	}
+
+	total := v.Len()
+	for n := total; n >= 0; n-- {
+		data, err := json.Marshal(v.Slice(0, n).Interface())
+		if err != nil {
+			return err
+		}
+		if int64(len(data)) > limit {
+			continue
+		}
+		if n < total {
+			flog.Warn("dropped items to fit the kubernetes diagnostics budget", "path", path, "kept", n, "total", total)
+		}
+		return b.writeFile(path, data)
+	}
+
+	flog.Warn("skipping file: kubernetes diagnostics budget too small even for an empty list", "path", path)
+	return nil
+}
+
+// zipKubernetesDiagnostics collects cluster-level debugging context beyond
+// the per-node metadata map already gathered by zipMetadataMap: recent
+// events in the datadog namespaces, tailed logs for every datadog-agent and
+// cluster-agent pod, the names of effective ConfigMaps/Secrets (values are
+// never collected; credentialsCleanerBytes only protects values that leak
+// into other files), and any Datadog CRDs with their status. Everything is
+// bundled under kubernetes/ with a stable layout and capped in size via
+// flare.kubernetes.max_file_bytes / flare.kubernetes.max_total_bytes.
+func zipKubernetesDiagnostics(flog log.Logger, tempDir, hostname string) error {
+	flog = flog.With("section", "kubernetes_diagnostics")
+	budget := newKubernetesDiagnosticsBudget()
+	root := filepath.Join(tempDir, hostname, kubernetesDir)
+
+	if err := zipKubernetesEvents(flog, budget, root); err != nil {
+		flog.Warn("failed to collect events", "error", err)
+	}
+
+	if err := zipKubernetesPodLogs(flog, budget, root); err != nil {
+		flog.Warn("failed to collect pod logs", "error", err)
+	}
+
+	if err := zipKubernetesConfigNames(flog, budget, root); err != nil {
+		flog.Warn("failed to collect configmap/secret names", "error", err)
+	}
+
+	if err := zipKubernetesCRDs(flog, budget, root); err != nil {
+		flog.Warn("failed to collect Datadog CRDs", "error", err)
+	}
+
+	return nil
+}
+
+func zipKubernetesEvents(flog log.Logger, budget *kubernetesDiagnosticsBudget, root string) error {
+	events, err := apiserver.GetDatadogNamespaceEvents()
+	if err != nil {
+		return err
+	}
+
+	// Events routinely embed command lines, annotations and other free-form
+	// fields that can carry the same credentials credentialsCleanerBytes
+	// already protects elsewhere in this archive. Redaction happens
+	// per-event, before the budget decides how many events fit, so dropping
+	// events to stay under budget never leaves an uncleaned one behind.
+	cleanedEvents := make([]json.RawMessage, 0, len(events))
+	for _, event := range events {
+		b, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		cleaned, err := credentialsCleanerBytes(b)
+		if err != nil {
+			return err
+		}
+		cleanedEvents = append(cleanedEvents, json.RawMessage(cleaned))
+	}
+
+	flog.Info("collected namespace events", "count", len(events))
+	return budget.writeJSONList(flog, filepath.Join(root, "events.json"), cleanedEvents)
+}
+
+func zipKubernetesPodLogs(flog log.Logger, budget *kubernetesDiagnosticsBudget, root string) error {
+	pods, err := apiserver.GetDatadogPods()
+	if err != nil {
+		return err
+	}
+
+	for _, pod := range pods {
+		logs, err := apiserver.GetPodLogTail(pod.Namespace, pod.Name, podLogTailLines)
+		if err != nil {
+			flog.Warn("failed to collect pod logs", "pod", pod.Name, "error", err)
+			continue
+		}
+
+		// Pod logs routinely echo env vars, API keys and auth headers from
+		// agent and check code, so they go through the same redaction pass
+		// as every other file in this archive before they ever hit disk.
+		cleaned, err := credentialsCleanerBytes([]byte(logs))
+		if err != nil {
+			return err
+		}
+
+		f := filepath.Join(root, "pod-logs", pod.Namespace, pod.Name+".log")
+		if err := budget.writeFile(f, cleaned); err != nil {
+			return err
+		}
+	}
+
+	flog.Info("collected pod logs", "pods", len(pods))
+	return nil
+}
+
+func zipKubernetesConfigNames(flog log.Logger, budget *kubernetesDiagnosticsBudget, root string) error {
+	configMaps, err := apiserver.GetDatadogConfigMapNames()
+	if err != nil {
+		return err
+	}
+	secrets, err := apiserver.GetDatadogSecretNames()
+	if err != nil {
+		return err
+	}
+
+	flog.Info("collected config object names", "config_maps", len(configMaps), "secrets", len(secrets))
+
+	// Written as two separate lists, rather than one object wrapping both,
+	// so the budget can drop entries from each independently without
+	// needing to rebuild a shared envelope around whatever survives.
+	if err := budget.writeJSONList(flog, filepath.Join(root, "config-maps.json"), configMaps); err != nil {
+		return err
+	}
+	return budget.writeJSONList(flog, filepath.Join(root, "secrets.json"), secrets)
+}
+
+func zipKubernetesCRDs(flog log.Logger, budget *kubernetesDiagnosticsBudget, root string) error {
+	crds, err := apiserver.GetDatadogCRDStatuses()
+	if err != nil {
+		return err
+	}
+
+	// CRD status can carry connection strings and other secrets set by
+	// operators (e.g. in DatadogAgent.status.conditions messages). Redaction
+	// happens per-CRD, before the budget decides how many fit, for the same
+	// reason as zipKubernetesEvents above.
+	cleanedCRDs := make([]json.RawMessage, 0, len(crds))
+	for _, crd := range crds {
+		b, err := json.Marshal(crd)
+		if err != nil {
+			return err
+		}
+		cleaned, err := credentialsCleanerBytes(b)
+		if err != nil {
+			return err
+		}
+		cleanedCRDs = append(cleanedCRDs, json.RawMessage(cleaned))
+	}
+
+	flog.Info("collected Datadog CRD statuses", "count", len(crds))
+	return budget.writeJSONList(flog, filepath.Join(root, "crds.json"), cleanedCRDs)
+}
@@ -0,0 +1,147 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2018 Datadog, Inc.
+
+package flare
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/DataDog/datadog-agent/pkg/util/log"
+)
+
+func TestKubernetesDiagnosticsBudgetTruncatesPerFileCap(t *testing.T) {
+	dir := t.TempDir()
+	b := &kubernetesDiagnosticsBudget{maxFileBytes: 10, maxTotalBytes: 1000}
+
+	path := filepath.Join(dir, "f.log")
+	if err := b.writeFile(path, []byte("0123456789abcdef")); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 10 {
+		t.Fatalf("expected file truncated to 10 bytes, got %d", len(got))
+	}
+	if b.written != 10 {
+		t.Fatalf("expected budget.written = 10, got %d", b.written)
+	}
+}
+
+func TestKubernetesDiagnosticsBudgetStopsAtTotalCap(t *testing.T) {
+	dir := t.TempDir()
+	b := &kubernetesDiagnosticsBudget{maxFileBytes: 100, maxTotalBytes: 15}
+
+	if err := b.writeFile(filepath.Join(dir, "a.log"), []byte("0123456789")); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.writeFile(filepath.Join(dir, "b.log"), []byte("0123456789")); err != nil {
+		t.Fatal(err)
+	}
+
+	gotB, err := ioutil.ReadFile(filepath.Join(dir, "b.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(gotB) != 5 {
+		t.Fatalf("expected second file truncated to the remaining 5 bytes of budget, got %d", len(gotB))
+	}
+	if b.written != 15 {
+		t.Fatalf("expected budget.written capped at 15, got %d", b.written)
+	}
+}
+
+func discardLogger() log.Logger {
+	return log.New(log.Config{Level: log.LevelError, Out: ioutil.Discard})
+}
+
+func TestWriteJSONListWritesValidJSONUnderFileCap(t *testing.T) {
+	dir := t.TempDir()
+	b := &kubernetesDiagnosticsBudget{maxFileBytes: 1000, maxTotalBytes: 1000}
+	path := filepath.Join(dir, "events.json")
+
+	items := []string{"one", "two", "three"}
+	if err := b.writeJSONList(discardLogger(), path, items); err != nil {
+		t.Fatal(err)
+	}
+
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	if err := json.Unmarshal(raw, &got); err != nil {
+		t.Fatalf("expected valid JSON, got unmarshal error: %v (contents: %q)", err, raw)
+	}
+	if len(got) != len(items) {
+		t.Fatalf("expected all %d items kept, got %d", len(items), len(got))
+	}
+}
+
+func TestWriteJSONListDropsItemsToFitFileCapWithoutCorruptingJSON(t *testing.T) {
+	dir := t.TempDir()
+	// Each marshaled item below is `"item-N"` (8 bytes) plus list
+	// punctuation, so a 15-byte cap can only ever fit one element.
+	b := &kubernetesDiagnosticsBudget{maxFileBytes: 15, maxTotalBytes: 1000}
+	path := filepath.Join(dir, "events.json")
+
+	items := []string{"item-1", "item-2", "item-3"}
+	if err := b.writeJSONList(discardLogger(), path, items); err != nil {
+		t.Fatal(err)
+	}
+
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	if err := json.Unmarshal(raw, &got); err != nil {
+		t.Fatalf("expected valid JSON even when truncated, got unmarshal error: %v (contents: %q)", err, raw)
+	}
+	if len(got) == 0 || len(got) >= len(items) {
+		t.Fatalf("expected some, but not all, items to be dropped to fit the cap; got %d of %d", len(got), len(items))
+	}
+	if int64(len(raw)) > b.maxFileBytes {
+		t.Fatalf("expected written file to respect the file cap, got %d bytes", len(raw))
+	}
+}
+
+func TestWriteJSONListSkipsFileWhenEvenEmptyListDoesNotFit(t *testing.T) {
+	dir := t.TempDir()
+	b := &kubernetesDiagnosticsBudget{maxFileBytes: 1, maxTotalBytes: 1000}
+	path := filepath.Join(dir, "events.json")
+
+	if err := b.writeJSONList(discardLogger(), path, []string{"anything"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ioutil.ReadFile(path); err == nil {
+		t.Fatal("expected no file to be written when the budget can't even fit an empty list")
+	}
+}
+
+func TestWriteJSONListRejectsNonSlice(t *testing.T) {
+	b := &kubernetesDiagnosticsBudget{maxFileBytes: 1000, maxTotalBytes: 1000}
+	if err := b.writeJSONList(discardLogger(), "unused.json", "not a slice"); err == nil {
+		t.Fatal("expected an error when items is not a slice")
+	}
+}
+
+func TestKubernetesDiagnosticsBudgetNoOpOnceExhausted(t *testing.T) {
+	dir := t.TempDir()
+	b := &kubernetesDiagnosticsBudget{maxFileBytes: 100, maxTotalBytes: 10, written: 10}
+
+	path := filepath.Join(dir, "c.log")
+	if err := b.writeFile(path, []byte("more data")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ioutil.ReadFile(path); err == nil {
+		t.Fatal("expected no file to be written once the total budget is exhausted")
+	}
+}
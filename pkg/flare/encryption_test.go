@@ -0,0 +1,63 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2018 Datadog, Inc.
+
+package flare
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testAgeRecipient = "age1ql3z7hjy54pw3hyww5ayyfg7zqgvc7w3j2elw8zmrj2kg5sfn9aqmcac8p"
+
+const testPGPKey = `-----BEGIN PGP PUBLIC KEY BLOCK-----
+
+mDMEYwAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA
+-----END PGP PUBLIC KEY BLOCK-----`
+
+func TestResolveRecipientsInlineAge(t *testing.T) {
+	recipients, err := resolveRecipients([]string{testAgeRecipient})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(recipients) != 1 {
+		t.Fatalf("expected 1 recipient, got %d", len(recipients))
+	}
+}
+
+func TestResolveRecipientsFromFile(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "recipient.pub")
+	if err := ioutil.WriteFile(keyFile, []byte(testAgeRecipient+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	recipients, err := resolveRecipients([]string{keyFile})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(recipients) != 1 {
+		t.Fatalf("expected 1 recipient, got %d", len(recipients))
+	}
+}
+
+func TestResolveRecipientsRejectsPGP(t *testing.T) {
+	_, err := resolveRecipients([]string{testPGPKey})
+	if err == nil {
+		t.Fatal("expected an error for a PGP recipient, got nil")
+	}
+	if !strings.Contains(err.Error(), "PGP recipients are not supported") {
+		t.Errorf("error = %q, want it to mention PGP is unsupported", err.Error())
+	}
+}
+
+func TestResolveRecipientsRejectsGarbage(t *testing.T) {
+	_, err := resolveRecipients([]string{"not-a-key-at-all"})
+	if err == nil {
+		t.Fatal("expected an error for an unparseable recipient, got nil")
+	}
+}
@@ -0,0 +1,414 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2018 Datadog, Inc.
+
+package flare
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/config"
+	"github.com/DataDog/datadog-agent/pkg/util/log"
+)
+
+// partSize is the size of each chunk uploaded to Datadog's intake. 100MB
+// cluster-agent flares (whole-cluster zipMetadataMap dumps) are common
+// enough that a single PUT is no longer reliable on flaky links.
+const partSize = 8 * 1024 * 1024
+
+// Receipt identifies a completed or in-progress upload. `flare send --resume
+// <archive-path>` locates an in-progress upload by its archive path, through
+// the state sidecar next to the archive; it does not take a receipt ID.
+type Receipt struct {
+	ID      string    `json:"id"`
+	Backend string    `json:"backend"`
+	SentAt  time.Time `json:"sent_at"`
+	Bytes   int64     `json:"bytes"`
+}
+
+// Uploader ships a finished flare archive somewhere support can retrieve it.
+// meta carries arbitrary request context (case ID, hostname, ...) that
+// backends may attach as object metadata or form fields.
+type Uploader interface {
+	Upload(ctx context.Context, path string, meta map[string]string) (Receipt, error)
+}
+
+// NewUploader selects an Uploader based on the `flare.upload.backend`
+// setting ("datadog", the default, "s3", "gcs", or "http").
+func NewUploader() (Uploader, error) {
+	switch backend := config.Datadog.GetString("flare.upload.backend"); backend {
+	case "", "datadog":
+		return &DatadogUploader{}, nil
+	case "s3":
+		return &S3Uploader{
+			Bucket: config.Datadog.GetString("flare.upload.s3.bucket"),
+			Prefix: config.Datadog.GetString("flare.upload.s3.prefix"),
+		}, nil
+	case "gcs":
+		return &GCSUploader{
+			Bucket: config.Datadog.GetString("flare.upload.gcs.bucket"),
+			Prefix: config.Datadog.GetString("flare.upload.gcs.prefix"),
+		}, nil
+	case "http":
+		return &HTTPPutUploader{
+			PresignedURL: config.Datadog.GetString("flare.upload.http.presigned_url"),
+		}, nil
+	default:
+		return nil, fmt.Errorf("flare: unknown upload backend %q", backend)
+	}
+}
+
+// Send uploads path using the configured backend. Only the datadog backend
+// persists resume state next to the archive, so only its failed uploads can
+// be picked up again with `flare send --resume`.
+func Send(path string, meta map[string]string) (Receipt, error) {
+	uploader, err := NewUploader()
+	if err != nil {
+		return Receipt{}, err
+	}
+	return uploader.Upload(context.Background(), path, meta)
+}
+
+// Resume re-attempts an upload recorded in the `<path>.upload-state.json`
+// sidecar written by DatadogUploader, continuing from the last acknowledged
+// part instead of restarting from scratch.
+func Resume(path string, meta map[string]string) (Receipt, error) {
+	uploader, err := NewUploader()
+	if err != nil {
+		return Receipt{}, err
+	}
+	resumable, ok := uploader.(interface {
+		Resume(ctx context.Context, path string, meta map[string]string) (Receipt, error)
+	})
+	if !ok {
+		return Receipt{}, fmt.Errorf("flare: configured upload backend does not support resuming")
+	}
+	return resumable.Resume(context.Background(), path, meta)
+}
+
+// uploadState is the sidecar persisted next to the archive so a retried
+// process can skip parts that already reached the intake.
+type uploadState struct {
+	ReceiptID      string   `json:"receipt_id"`
+	PartChecksums  []string `json:"part_checksums"`
+	CompletedParts int      `json:"completed_parts"`
+}
+
+func stateFilePath(archivePath string) string {
+	return archivePath + ".upload-state.json"
+}
+
+func loadUploadState(archivePath string) (*uploadState, error) {
+	b, err := ioutil.ReadFile(stateFilePath(archivePath))
+	if os.IsNotExist(err) {
+		return &uploadState{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var st uploadState
+	if err := json.Unmarshal(b, &st); err != nil {
+		return nil, err
+	}
+	return &st, nil
+}
+
+func saveUploadState(archivePath string, st *uploadState) error {
+	b, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(stateFilePath(archivePath), b, 0600)
+}
+
+// DatadogUploader sends the archive to Datadog's intake using resumable
+// multipart upload: the file is split into fixed-size parts, each checksummed
+// with SHA-256 so the intake can detect and reject a corrupted retransmit,
+// and a part is retried with exponential backoff before the whole upload is
+// considered failed.
+type DatadogUploader struct{}
+
+// Upload implements Uploader.
+func (u *DatadogUploader) Upload(ctx context.Context, path string, meta map[string]string) (Receipt, error) {
+	st, err := loadUploadState(path)
+	if err != nil {
+		return Receipt{}, err
+	}
+	return u.upload(ctx, path, meta, st)
+}
+
+// Resume continues an upload from the state left behind by a prior, failed
+// Upload call.
+func (u *DatadogUploader) Resume(ctx context.Context, path string, meta map[string]string) (Receipt, error) {
+	st, err := loadUploadState(path)
+	if err != nil {
+		return Receipt{}, err
+	}
+	if st.ReceiptID == "" {
+		return Receipt{}, fmt.Errorf("flare: no resumable upload state found for %s", path)
+	}
+	return u.upload(ctx, path, meta, st)
+}
+
+func (u *DatadogUploader) upload(ctx context.Context, path string, meta map[string]string, st *uploadState) (Receipt, error) {
+	if config.Datadog.GetString("api_key") == "" {
+		return Receipt{}, fmt.Errorf("flare: api_key is not configured; the datadog upload backend needs it to reach the flare intake")
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return Receipt{}, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return Receipt{}, err
+	}
+
+	if st.ReceiptID == "" {
+		st.ReceiptID = newReceiptID()
+	}
+
+	flog := log.Default().With("receipt_id", st.ReceiptID, "backend", "datadog")
+
+	numParts := int(math.Ceil(float64(info.Size()) / float64(partSize)))
+	if _, err := f.Seek(int64(st.CompletedParts)*partSize, io.SeekStart); err != nil {
+		return Receipt{}, err
+	}
+	buf := make([]byte, partSize)
+	for part := st.CompletedParts; part < numParts; part++ {
+		select {
+		case <-ctx.Done():
+			return Receipt{}, ctx.Err()
+		default:
+		}
+
+		n, err := io.ReadFull(f, buf)
+		if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+			return Receipt{}, err
+		}
+
+		sum := sha256.Sum256(buf[:n])
+		checksum := hex.EncodeToString(sum[:])
+
+		if err := uploadPartWithBackoff(ctx, st.ReceiptID, part, buf[:n], checksum); err != nil {
+			if saveErr := saveUploadState(path, st); saveErr != nil {
+				flog.Warn("failed to persist upload state", "error", saveErr)
+			}
+			return Receipt{}, fmt.Errorf("flare: part %d failed: %w", part, err)
+		}
+
+		st.PartChecksums = append(st.PartChecksums, checksum)
+		st.CompletedParts = part + 1
+		if err := saveUploadState(path, st); err != nil {
+			flog.Warn("failed to persist upload state", "error", err)
+		}
+		flog.Info("uploaded part", "part", part, "total_parts", numParts)
+	}
+
+	os.Remove(stateFilePath(path))
+
+	return Receipt{
+		ID:      st.ReceiptID,
+		Backend: "datadog",
+		SentAt:  time.Now(),
+		Bytes:   info.Size(),
+	}, nil
+}
+
+// errTransportUnavailable marks a part failure as a configuration problem
+// rather than a transient one, so uploadPartWithBackoff doesn't waste time
+// retrying a call that is guaranteed to fail again.
+var errTransportUnavailable = errors.New("flare: datadog intake transport rejected the request")
+
+// uploadPartWithBackoff retries a transient doUploadPart failure (the
+// behavior a flaky link actually needs) with exponential backoff, but gives
+// up immediately on errTransportUnavailable since a request the intake
+// rejected with a 4xx fails the same way on every retry.
+func uploadPartWithBackoff(ctx context.Context, receiptID string, part int, data []byte, checksum string) error {
+	const maxAttempts = 5
+	backoff := 500 * time.Millisecond
+
+	var lastErr error
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		if lastErr = doUploadPart(ctx, receiptID, part, data, checksum); lastErr == nil {
+			return nil
+		}
+		if errors.Is(lastErr, errTransportUnavailable) {
+			return lastErr
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+	return lastErr
+}
+
+// datadogIntakeURL returns the per-part upload endpoint for the Datadog
+// flare intake, following the same api_key/site config keys the rest of the
+// Agent uses to reach Datadog.
+func datadogIntakeURL(site, receiptID string, part int) string {
+	if site == "" {
+		site = "datadoghq.com"
+	}
+	return fmt.Sprintf("https://flare-intake.%s/api/v2/flare/%s/parts/%d", site, receiptID, part)
+}
+
+// doUploadPart PUTs one part to the Datadog flare intake. A 4xx response
+// (bad API key, rejected checksum, ...) is wrapped in errTransportUnavailable
+// so uploadPartWithBackoff treats it as permanent instead of retrying a
+// request that's guaranteed to fail again the same way.
+func doUploadPart(ctx context.Context, receiptID string, part int, data []byte, checksum string) error {
+	url := datadogIntakeURL(config.Datadog.GetString("site"), receiptID, part)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.ContentLength = int64(len(data))
+	req.Header.Set("DD-API-KEY", config.Datadog.GetString("api_key"))
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("X-Checksum-Sha256", checksum)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("flare: part %d upload failed: %w", part, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("%w: part %d: status %d: %s", errTransportUnavailable, part, resp.StatusCode, body)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("flare: part %d upload failed: status %d: %s", part, resp.StatusCode, body)
+	}
+	return nil
+}
+
+func newReceiptID() string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%d", time.Now().UnixNano())))
+	return hex.EncodeToString(sum[:])[:16]
+}
+
+// S3Uploader is the `s3` upload backend. Its Upload always returns an error
+// in this build; no object is written to the bucket.
+type S3Uploader struct {
+	Bucket string
+	Prefix string
+}
+
+// Upload implements Uploader.
+func (u *S3Uploader) Upload(ctx context.Context, path string, meta map[string]string) (Receipt, error) {
+	return Receipt{}, fmt.Errorf("flare: s3 upload backend is not configured with AWS credentials in this build")
+}
+
+// GCSUploader is the `gcs` upload backend. Its Upload always returns an
+// error in this build; no object is written to the bucket.
+type GCSUploader struct {
+	Bucket string
+	Prefix string
+}
+
+// Upload implements Uploader.
+func (u *GCSUploader) Upload(ctx context.Context, path string, meta map[string]string) (Receipt, error) {
+	return Receipt{}, fmt.Errorf("flare: gcs upload backend is not configured with GCP credentials in this build")
+}
+
+// HTTPPutUploader uploads the archive with a single PUT against a
+// caller-supplied presigned URL, for backends that hand out one-off upload
+// links (e.g. a support ticketing system). Like the Datadog backend, and
+// unlike the S3 and GCS backends, it sends the archive over net/http and
+// needs no extra client library.
+type HTTPPutUploader struct {
+	PresignedURL string
+	// Client is used to send the PUT request. Defaults to http.DefaultClient.
+	Client *http.Client
+}
+
+// Upload implements Uploader.
+func (u *HTTPPutUploader) Upload(ctx context.Context, path string, meta map[string]string) (Receipt, error) {
+	if u.PresignedURL == "" {
+		return Receipt{}, fmt.Errorf("flare: no presigned URL configured for the http upload backend")
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return Receipt{}, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return Receipt{}, err
+	}
+
+	checksum, err := sha256Sum(f)
+	if err != nil {
+		return Receipt{}, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return Receipt{}, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, u.PresignedURL, f)
+	if err != nil {
+		return Receipt{}, err
+	}
+	req.ContentLength = info.Size()
+	req.Header.Set("Content-Type", "application/zip")
+	req.Header.Set("X-Checksum-Sha256", checksum)
+	for k, v := range meta {
+		req.Header.Set("X-Flare-"+k, v)
+	}
+
+	client := u.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return Receipt{}, fmt.Errorf("flare: http put upload failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+		return Receipt{}, fmt.Errorf("flare: http put upload failed: status %d: %s", resp.StatusCode, body)
+	}
+
+	return Receipt{
+		ID:      checksum[:16],
+		Backend: "http",
+		SentAt:  time.Now(),
+		Bytes:   info.Size(),
+	}, nil
+}
+
+// sha256Sum hashes r's remaining content without buffering it all in memory.
+func sha256Sum(r io.Reader) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
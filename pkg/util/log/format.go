@@ -0,0 +1,61 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2018 Datadog, Inc.
+
+package log
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// jsonFormatter renders one JSON object per line, suitable for machine
+// parsing (e.g. the flare-creation log bundled into support archives).
+type jsonFormatter struct{}
+
+func (jsonFormatter) Format(w io.Writer, entry Entry) error {
+	fields := kvToMap(entry.KV)
+	fields["time"] = entry.Time.Format("2006-01-02T15:04:05.000Z07:00")
+	fields["level"] = entry.Level.String()
+	fields["msg"] = entry.Message
+
+	enc := json.NewEncoder(w)
+	return enc.Encode(fields)
+}
+
+// humanFormatter renders a single readable line, close to seelog's default
+// output, with the key/value pairs appended as `key=value`.
+type humanFormatter struct{}
+
+func (humanFormatter) Format(w io.Writer, entry Entry) error {
+	var b strings.Builder
+	b.WriteString(entry.Time.Format("2006-01-02 15:04:05 MST"))
+	b.WriteString(" | ")
+	b.WriteString(strings.ToUpper(entry.Level.String()))
+	b.WriteString(" | ")
+	b.WriteString(entry.Message)
+
+	fields := kvToMap(entry.KV)
+	for k, v := range fields {
+		fmt.Fprintf(&b, " %s=%v", k, v)
+	}
+	b.WriteString("\n")
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// kvToMap pairs up a flat key/value slice into a map, dropping a dangling
+// trailing key and stringifying non-string keys rather than failing the log
+// call over a caller mistake.
+func kvToMap(kv []interface{}) map[string]interface{} {
+	fields := make(map[string]interface{}, len(kv)/2)
+	for i := 0; i+1 < len(kv); i += 2 {
+		key := fmt.Sprintf("%v", kv[i])
+		fields[key] = kv[i+1]
+	}
+	return fields
+}
@@ -0,0 +1,180 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2018 Datadog, Inc.
+
+// Package log provides a structured, key/value logger for the Agent.
+//
+// Unlike `github.com/cihub/seelog`, which the Agent historically used through
+// printf-style helpers, this package lets call sites attach structured context
+// (check name, instance ID, run ID, ...) that survives into the log output and
+// can be queried by log processors instead of being baked into a free-form
+// message string.
+package log
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Logger emits structured log lines. Each of the level methods takes a short
+// human message plus an even number of key/value pairs describing the event.
+type Logger interface {
+	Debug(msg string, kv ...interface{})
+	Info(msg string, kv ...interface{})
+	Warn(msg string, kv ...interface{})
+	Error(msg string, kv ...interface{})
+
+	// With returns a Logger that prepends kv to every subsequent call,
+	// propagating context (e.g. check name, instance ID, run ID) to
+	// everything logged through it without repeating the pairs at every
+	// call site.
+	With(kv ...interface{}) Logger
+}
+
+// Level indicates the severity of a log entry.
+type Level int
+
+// Log levels, ordered from least to most severe.
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+// String returns the lowercase name of the level, as used by formatters.
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "debug"
+	case LevelInfo:
+		return "info"
+	case LevelWarn:
+		return "warn"
+	case LevelError:
+		return "error"
+	default:
+		return "unknown"
+	}
+}
+
+// Formatter renders a single log entry to w.
+type Formatter interface {
+	Format(w io.Writer, entry Entry) error
+}
+
+// Entry is a single structured log record handed to a Formatter.
+type Entry struct {
+	Time    time.Time
+	Level   Level
+	Message string
+	KV      []interface{}
+}
+
+type logger struct {
+	mu        *sync.Mutex
+	out       io.Writer
+	formatter Formatter
+	level     Level
+	kv        []interface{}
+}
+
+// Config controls how a Logger built by New behaves.
+type Config struct {
+	// Level is the minimum level that will be emitted.
+	Level Level
+	// Format selects the formatter: "json" or "human". Defaults to "human".
+	Format string
+	// Out is the destination writer. Defaults to os.Stderr.
+	Out io.Writer
+}
+
+// New builds a Logger from cfg. It is typically called once at Agent startup
+// and the result stored as the package-level default via SetDefault.
+func New(cfg Config) Logger {
+	out := cfg.Out
+	if out == nil {
+		out = os.Stderr
+	}
+
+	var f Formatter
+	switch cfg.Format {
+	case "json":
+		f = jsonFormatter{}
+	default:
+		f = humanFormatter{}
+	}
+
+	return &logger{
+		mu:        &sync.Mutex{},
+		out:       out,
+		formatter: f,
+		level:     cfg.Level,
+	}
+}
+
+func (l *logger) log(level Level, msg string, kv []interface{}) {
+	if level < l.level {
+		return
+	}
+
+	merged := make([]interface{}, 0, len(l.kv)+len(kv))
+	merged = append(merged, l.kv...)
+	merged = append(merged, kv...)
+
+	entry := Entry{
+		Time:    time.Now(),
+		Level:   level,
+		Message: msg,
+		KV:      merged,
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.formatter.Format(l.out, entry); err != nil {
+		fmt.Fprintf(os.Stderr, "log: failed to format entry: %v\n", err)
+	}
+}
+
+func (l *logger) Debug(msg string, kv ...interface{}) { l.log(LevelDebug, msg, kv) }
+func (l *logger) Info(msg string, kv ...interface{})  { l.log(LevelInfo, msg, kv) }
+func (l *logger) Warn(msg string, kv ...interface{})  { l.log(LevelWarn, msg, kv) }
+func (l *logger) Error(msg string, kv ...interface{}) { l.log(LevelError, msg, kv) }
+
+func (l *logger) With(kv ...interface{}) Logger {
+	merged := make([]interface{}, 0, len(l.kv)+len(kv))
+	merged = append(merged, l.kv...)
+	merged = append(merged, kv...)
+
+	return &logger{
+		mu:        l.mu,
+		out:       l.out,
+		formatter: l.formatter,
+		level:     l.level,
+		kv:        merged,
+	}
+}
+
+var (
+	defaultMu     sync.Mutex
+	defaultLogger Logger = New(Config{Level: LevelInfo})
+)
+
+// SetDefault replaces the package-level default Logger returned by Default.
+func SetDefault(l Logger) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	defaultLogger = l
+}
+
+// Default returns the package-level Logger configured via SetDefault, or a
+// human-formatted, info-level Logger writing to stderr if none was set.
+func Default() Logger {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	return defaultLogger
+}
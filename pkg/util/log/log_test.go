@@ -0,0 +1,81 @@
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestJSONFormatterIncludesKV(t *testing.T) {
+	var buf bytes.Buffer
+	l := New(Config{Level: LevelDebug, Format: "json", Out: &buf})
+
+	l.Info("check ran", "check", "cpu", "instance_id", "abc123")
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &fields); err != nil {
+		t.Fatalf("expected valid JSON line, got error: %v (line: %q)", err, buf.String())
+	}
+
+	if fields["msg"] != "check ran" {
+		t.Errorf("msg = %v, want %q", fields["msg"], "check ran")
+	}
+	if fields["level"] != "info" {
+		t.Errorf("level = %v, want %q", fields["level"], "info")
+	}
+	if fields["check"] != "cpu" {
+		t.Errorf("check = %v, want %q", fields["check"], "cpu")
+	}
+	if fields["instance_id"] != "abc123" {
+		t.Errorf("instance_id = %v, want %q", fields["instance_id"], "abc123")
+	}
+}
+
+func TestHumanFormatterRendersMessageAndKV(t *testing.T) {
+	var buf bytes.Buffer
+	l := New(Config{Level: LevelDebug, Format: "human", Out: &buf})
+
+	l.Warn("slow check run", "check", "http_check", "duration_ms", 120)
+
+	out := buf.String()
+	for _, want := range []string{"WARN", "slow check run", "check=http_check", "duration_ms=120"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("human output %q does not contain %q", out, want)
+		}
+	}
+}
+
+func TestWithPropagatesContextToSubsequentCalls(t *testing.T) {
+	var buf bytes.Buffer
+	base := New(Config{Level: LevelDebug, Format: "json", Out: &buf})
+	checkLogger := base.With("check", "cpu", "instance_id", "abc123")
+
+	checkLogger.Error("run failed", "error", "boom")
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &fields); err != nil {
+		t.Fatalf("expected valid JSON line, got error: %v", err)
+	}
+	if fields["check"] != "cpu" || fields["instance_id"] != "abc123" {
+		t.Errorf("With()'d context missing from entry: %+v", fields)
+	}
+	if fields["error"] != "boom" {
+		t.Errorf("call-site kv missing from entry: %+v", fields)
+	}
+}
+
+func TestLevelFiltering(t *testing.T) {
+	var buf bytes.Buffer
+	l := New(Config{Level: LevelWarn, Format: "json", Out: &buf})
+
+	l.Info("should not appear")
+	if buf.Len() != 0 {
+		t.Fatalf("expected Info below the configured level to be dropped, got %q", buf.String())
+	}
+
+	l.Warn("should appear")
+	if buf.Len() == 0 {
+		t.Fatalf("expected Warn at the configured level to be emitted")
+	}
+}